@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fflewddur/dpkg-builder/pkg/builder"
+	"github.com/urfave/cli"
+)
+
+// repreproBatchSize caps how many files go into a single reprepro exec, to
+// stay under the OS's command-line length limit and to amortize signing
+// cost across many files instead of paying it once per file.
+const repreproBatchSize = 500
+
+const defaultRepoBase string = "repo"
+
+func commandPublish(c *cli.Context) error {
+	inputDir := c.String("input")
+	repoBase := c.String("repo")
+	distribution := c.String("distribution")
+	signKey := c.String("sign-key")
+
+	if distribution == "" {
+		return errors.New("publish: --distribution is required")
+	}
+
+	debs, err := filepath.Glob(filepath.Join(inputDir, "*.deb"))
+	if err != nil {
+		return &builder.Error{Phase: "publish", Err: err}
+	}
+	if len(debs) == 0 {
+		return &builder.Error{Phase: "publish", Err: fmt.Errorf("no .deb files found in %s", inputDir)}
+	}
+
+	if err := ensureSignWith(repoBase, distribution, signKey); err != nil {
+		return &builder.Error{Phase: "publish", Err: err}
+	}
+
+	log.Printf("Publishing %d package(s) from %s into %s (%s)...", len(debs), inputDir, repoBase, distribution)
+
+	ctx := context.Background()
+	for _, batch := range batchFiles(debs, repreproBatchSize) {
+		if err := repreproIncludedeb(ctx, repoBase, distribution, batch); err != nil {
+			return &builder.Error{Phase: "publish", Err: err}
+		}
+	}
+
+	log.Printf("Published %d package(s) to %s.", len(debs), repoBase)
+
+	return nil
+}
+
+// repreproIncludedeb inserts debs into repoBase's distribution. reprepro
+// signs the Release/InRelease it (re-)generates on its own, using whatever
+// SignWith: key is configured for that distribution in conf/distributions.
+func repreproIncludedeb(ctx context.Context, repoBase, distribution string, debs []string) error {
+	args := []string{"-b", repoBase, "includedeb", distribution}
+	args = append(args, debs...)
+
+	return builder.RunLogged(exec.CommandContext(ctx, "reprepro", args...))
+}
+
+// ensureSignWith makes sure repoBase's conf/distributions has a SignWith:
+// entry for distribution's Codename, so reprepro actually signs the Release/
+// InRelease it generates with signKey. (reprepro's own -S flag overrides the
+// Section: field on included packages, not signing, so that can't be used
+// for this.) signKey == "" is a no-op: the distribution is signed or not
+// however conf/distributions already has it configured.
+func ensureSignWith(repoBase, distribution, signKey string) error {
+	if signKey == "" {
+		return nil
+	}
+
+	path := filepath.Join(repoBase, "conf", "distributions")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("error reading %s: %s", path, err)
+	}
+
+	paragraphs := strings.Split(string(data), "\n\n")
+	found := false
+	for i, p := range paragraphs {
+		if !paragraphHasCodename(p, distribution) {
+			continue
+		}
+		found = true
+		paragraphs[i] = setSignWith(p, signKey)
+	}
+	if !found {
+		return fmt.Errorf("no Codename: %s paragraph in %s", distribution, path)
+	}
+
+	if err := os.WriteFile(path, []byte(strings.Join(paragraphs, "\n\n")), 0644); err != nil {
+		return fmt.Errorf("error writing %s: %s", path, err)
+	}
+
+	return nil
+}
+
+// paragraphHasCodename reports whether paragraph (one stanza of
+// conf/distributions) is for the given Codename.
+func paragraphHasCodename(paragraph, distribution string) bool {
+	for _, line := range strings.Split(paragraph, "\n") {
+		key, val, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) == "Codename" && strings.TrimSpace(val) == distribution {
+			return true
+		}
+	}
+	return false
+}
+
+// setSignWith returns paragraph with its SignWith: line set to signKey,
+// adding one if it doesn't already have one.
+func setSignWith(paragraph, signKey string) string {
+	lines := strings.Split(paragraph, "\n")
+	for i, line := range lines {
+		key, _, ok := strings.Cut(line, ":")
+		if ok && strings.TrimSpace(key) == "SignWith" {
+			lines[i] = "SignWith: " + signKey
+			return strings.Join(lines, "\n")
+		}
+	}
+	return paragraph + "\nSignWith: " + signKey
+}
+
+// batchFiles splits files into chunks of at most size.
+func batchFiles(files []string, size int) [][]string {
+	var batches [][]string
+	for len(files) > 0 {
+		n := size
+		if n > len(files) {
+			n = len(files)
+		}
+		batches = append(batches, files[:n])
+		files = files[n:]
+	}
+	return batches
+}
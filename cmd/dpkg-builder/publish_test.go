@@ -0,0 +1,91 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBatchFiles(t *testing.T) {
+	tests := []struct {
+		name  string
+		files []string
+		size  int
+		want  [][]string
+	}{
+		{
+			name:  "empty",
+			files: nil,
+			size:  2,
+			want:  nil,
+		},
+		{
+			name:  "evenly divides",
+			files: []string{"a", "b", "c", "d"},
+			size:  2,
+			want:  [][]string{{"a", "b"}, {"c", "d"}},
+		},
+		{
+			name:  "partial final batch",
+			files: []string{"a", "b", "c"},
+			size:  2,
+			want:  [][]string{{"a", "b"}, {"c"}},
+		},
+		{
+			name:  "size larger than input",
+			files: []string{"a", "b"},
+			size:  500,
+			want:  [][]string{{"a", "b"}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := batchFiles(tt.files, tt.size)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("batchFiles(%v, %d) = %v, want %v", tt.files, tt.size, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetSignWith(t *testing.T) {
+	tests := []struct {
+		name      string
+		paragraph string
+		signKey   string
+		want      string
+	}{
+		{
+			name:      "adds a missing SignWith",
+			paragraph: "Codename: bookworm\nComponents: main",
+			signKey:   "ABCDEF1234",
+			want:      "Codename: bookworm\nComponents: main\nSignWith: ABCDEF1234",
+		},
+		{
+			name:      "replaces an existing SignWith",
+			paragraph: "Codename: bookworm\nSignWith: OLDKEY\nComponents: main",
+			signKey:   "NEWKEY",
+			want:      "Codename: bookworm\nSignWith: NEWKEY\nComponents: main",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := setSignWith(tt.paragraph, tt.signKey)
+			if got != tt.want {
+				t.Errorf("setSignWith(%q, %q) = %q, want %q", tt.paragraph, tt.signKey, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParagraphHasCodename(t *testing.T) {
+	paragraph := "Origin: example\nCodename: bookworm\nComponents: main"
+
+	if !paragraphHasCodename(paragraph, "bookworm") {
+		t.Error("expected match for bookworm")
+	}
+	if paragraphHasCodename(paragraph, "bullseye") {
+		t.Error("unexpected match for bullseye")
+	}
+}
@@ -0,0 +1,260 @@
+// Package builder turns a fetched Debian source package into .deb files,
+// using one of several backends: the host's own dpkg-buildpackage, an
+// sbuild or pbuilder chroot, or a throwaway Docker container.
+package builder
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+)
+
+// Backends supported by Options.Backend.
+const (
+	BackendNative   string = "native"
+	BackendSbuild   string = "sbuild"
+	BackendPbuilder string = "pbuilder"
+	BackendDocker   string = "docker"
+)
+
+// DefaultOutputDir is used when Options.OutputDir is empty.
+const DefaultOutputDir string = "out"
+
+// Error identifies which phase of the build pipeline failed, so callers can
+// distinguish e.g. a missing build-dep from an FTBFS without string-matching
+// log output.
+type Error struct {
+	Phase string
+	Err   error
+}
+
+func (e *Error) Error() string { return fmt.Sprintf("build: %s: %s", e.Phase, e.Err) }
+func (e *Error) Unwrap() error { return e.Err }
+
+// Options configures a Build.
+type Options struct {
+	Backend   string // one of the Backend* constants; defaults to BackendNative
+	Arch      string // target architecture, e.g. amd64, arm64; empty uses the host's
+	Suite     string // Debian suite, needed by the sbuild/pbuilder/docker backends
+	OutputDir string // where to collect the resulting .deb/.changes/.buildinfo; defaults to DefaultOutputDir
+}
+
+// Build produces .deb files for a source package and returns the paths of
+// the artifacts it collected. srcDir is the unpacked source tree (used by
+// BackendNative); dscPath is the package's .dsc (used by every other
+// backend, which unpack it themselves inside a chroot or container).
+func Build(ctx context.Context, srcDir, dscPath string, opts Options) ([]string, error) {
+	outDir := opts.OutputDir
+	if outDir == "" {
+		outDir = DefaultOutputDir
+	}
+
+	switch opts.Backend {
+	case BackendNative, "":
+		return buildNative(ctx, srcDir, opts.Arch, outDir)
+	case BackendSbuild, BackendPbuilder:
+		return buildInChroot(ctx, opts.Backend, dscPath, opts.Arch, opts.Suite, outDir)
+	case BackendDocker:
+		return buildInDocker(ctx, dscPath, opts.Arch, opts.Suite, outDir)
+	default:
+		return nil, &Error{Phase: "backend", Err: fmt.Errorf("unknown backend %q", opts.Backend)}
+	}
+}
+
+// buildNative installs srcDir's build-deps via the local apt cache, then
+// runs dpkg-buildpackage directly on the host.
+func buildNative(ctx context.Context, srcDir, arch, outDir string) ([]string, error) {
+	if err := RunLogged(exec.CommandContext(ctx, "apt-get", "build-dep", "-y", srcDir)); err != nil {
+		return nil, &Error{Phase: "install-build-deps", Err: err}
+	}
+
+	cmd := exec.CommandContext(ctx, "dpkg-buildpackage", "-us", "-uc", "-b")
+	if arch != "" {
+		cmd.Args = append(cmd.Args, "--host-arch="+arch)
+	}
+	cmd.Dir = srcDir
+
+	if err := RunLogged(cmd); err != nil {
+		return nil, &Error{Phase: "ftbfs", Err: fmt.Errorf("dpkg-buildpackage: %s", err)}
+	}
+
+	artifacts, err := collectArtifacts(filepath.Dir(srcDir), outDir)
+	if err != nil {
+		return nil, &Error{Phase: "collect-artifacts", Err: err}
+	}
+
+	return artifacts, nil
+}
+
+// buildInChroot hands the .dsc straight to sbuild or pbuilder, which unpack,
+// resolve build-deps, and build it inside a clean chroot.
+func buildInChroot(ctx context.Context, backend, dscPath, arch, suite, outDir string) ([]string, error) {
+	if err := ensureOutputDir(outDir); err != nil {
+		return nil, &Error{Phase: "output-dir", Err: err}
+	}
+
+	var cmd *exec.Cmd
+	switch backend {
+	case BackendSbuild:
+		args := []string{"--dist=" + suite, "--build-dir=" + outDir}
+		if arch != "" {
+			args = append(args, "--arch="+arch)
+		}
+		args = append(args, dscPath)
+		cmd = exec.CommandContext(ctx, "sbuild", args...)
+	case BackendPbuilder:
+		args := []string{"build", "--distribution", suite, "--buildresult", outDir}
+		if arch != "" {
+			args = append(args, "--architecture", arch)
+		}
+		args = append(args, dscPath)
+		cmd = exec.CommandContext(ctx, "pbuilder", args...)
+	}
+
+	if err := RunLogged(cmd); err != nil {
+		return nil, &Error{Phase: "ftbfs", Err: fmt.Errorf("%s: %s", backend, err)}
+	}
+
+	artifacts, err := globArtifacts(outDir)
+	if err != nil {
+		return nil, &Error{Phase: "collect-artifacts", Err: err}
+	}
+
+	return artifacts, nil
+}
+
+// buildInDocker runs the same apt-get build-dep + dpkg-buildpackage sequence
+// as buildNative, but inside a throwaway debian:<suite> container so the
+// host's apt cache is never touched.
+func buildInDocker(ctx context.Context, dscPath, arch, suite, outDir string) ([]string, error) {
+	if err := ensureOutputDir(outDir); err != nil {
+		return nil, &Error{Phase: "output-dir", Err: err}
+	}
+
+	srcDir, err := filepath.Abs(filepath.Dir(dscPath))
+	if err != nil {
+		return nil, &Error{Phase: "output-dir", Err: err}
+	}
+	absOut, err := filepath.Abs(outDir)
+	if err != nil {
+		return nil, &Error{Phase: "output-dir", Err: err}
+	}
+	_, dscFile := filepath.Split(dscPath)
+
+	hostArch := ""
+	if arch != "" {
+		hostArch = " --host-arch=" + arch
+	}
+
+	buildScript := fmt.Sprintf(
+		"dpkg-source -x --no-check %s && cd */ && apt-get update && apt-get build-dep -y . && dpkg-buildpackage -us -uc -b%s && mv ../*.deb ../*.changes ../*.buildinfo /out/",
+		dscFile, hostArch,
+	)
+
+	cmd := exec.CommandContext(ctx, "docker", "run", "--rm",
+		"-v", srcDir+":/src",
+		"-v", absOut+":/out",
+		"-w", "/src",
+		"debian:"+suite,
+		"sh", "-c", buildScript,
+	)
+
+	if err := RunLogged(cmd); err != nil {
+		return nil, &Error{Phase: "ftbfs", Err: fmt.Errorf("docker: %s", err)}
+	}
+
+	artifacts, err := globArtifacts(outDir)
+	if err != nil {
+		return nil, &Error{Phase: "collect-artifacts", Err: err}
+	}
+
+	return artifacts, nil
+}
+
+// collectArtifacts moves the .deb/.changes/.buildinfo files dpkg-buildpackage
+// left in parentDir (one level up from the unpacked source tree) into outDir.
+func collectArtifacts(parentDir, outDir string) ([]string, error) {
+	if err := ensureOutputDir(outDir); err != nil {
+		return nil, err
+	}
+
+	var moved []string
+	for _, pattern := range []string{"*.deb", "*.changes", "*.buildinfo"} {
+		matches, err := filepath.Glob(filepath.Join(parentDir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			_, name := filepath.Split(m)
+			dest := filepath.Join(outDir, name)
+			if err := os.Rename(m, dest); err != nil {
+				return nil, fmt.Errorf("error moving %s to %s: %s", m, dest, err)
+			}
+			moved = append(moved, dest)
+		}
+	}
+
+	return moved, nil
+}
+
+// globArtifacts lists the .deb/.changes/.buildinfo files already present in
+// dir, for backends that write their output there directly.
+func globArtifacts(dir string) ([]string, error) {
+	var found []string
+	for _, pattern := range []string{"*.deb", "*.changes", "*.buildinfo"} {
+		matches, err := filepath.Glob(filepath.Join(dir, pattern))
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, matches...)
+	}
+	return found, nil
+}
+
+func ensureOutputDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("error creating output directory %s: %s", dir, err)
+	}
+	return nil
+}
+
+// RunLogged runs cmd to completion, streaming its stdout and stderr through
+// log.Println line by line. It's exported so callers outside this package
+// (e.g. the publish step's reprepro invocations) get the same behavior.
+func RunLogged(cmd *exec.Cmd) error {
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("error creating StdoutPipe: %s", err)
+	}
+	stderr, err := cmd.StderrPipe()
+	if err != nil {
+		return fmt.Errorf("error creating StderrPipe: %s", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, r := range []io.Reader{stdout, stderr} {
+		wg.Add(1)
+		scanner := bufio.NewScanner(r)
+		go func() {
+			defer wg.Done()
+			for scanner.Scan() {
+				log.Println(scanner.Text())
+			}
+		}()
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("error starting %s: %s", cmd.Path, err)
+	}
+
+	err = cmd.Wait()
+	wg.Wait()
+
+	return err
+}
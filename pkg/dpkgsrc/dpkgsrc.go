@@ -0,0 +1,245 @@
+// Package dpkgsrc resolves a Debian source package name to its .dsc and
+// tarball files, downloads them, and unpacks them, either against a suite's
+// Sources index or a pinned snapshot.debian.org timestamp.
+package dpkgsrc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fflewddur/dpkg-builder/pkg/fetch"
+	"github.com/fflewddur/dpkg-builder/pkg/sources"
+)
+
+const (
+	// DebianMirrorBaseURL is the default mirror used to resolve and fetch
+	// source packages.
+	DebianMirrorBaseURL string = "https://deb.debian.org/debian"
+	// DefaultSuite is used when no suite is specified.
+	DefaultSuite string = "bookworm"
+)
+
+// mirrorHosts are tried, in order, whenever a primary download 404s or 5xxs.
+var mirrorHosts = []string{"deb.debian.org", "ftp.us.debian.org", "snapshot.debian.org"}
+
+// Src is a single source package: its name, the URLs of its .dsc and
+// tarballs, and where those files ended up on disk once fetched.
+type Src struct {
+	Name      string
+	DSC       string
+	Orig      string
+	Debian    string
+	DscPath   string
+	Checksums map[string]string // filename -> sha256, as resolved from the Sources index; empty when Snapshot is set
+	Snapshot  string            // snapshot.debian.org timestamp, e.g. 20200601T000000Z; empty means use the Sources index
+}
+
+// New returns a Src for the given package name.
+func New(name string) *Src {
+	return &Src{Name: name}
+}
+
+// Resolve looks d.Name up in the given suite's Sources index and fills in
+// d's .dsc and tarball URLs. It has no effect if d.Snapshot is set, since
+// fetchFromSnapshot resolves against snapshot.debian.org instead.
+func (d *Src) Resolve(ctx context.Context, suite string) error {
+	if d.Snapshot != "" {
+		return nil
+	}
+
+	idx := sources.New(DebianMirrorBaseURL, suite, "main")
+	if err := idx.Load(ctx); err != nil {
+		return err
+	}
+
+	resolved, err := idx.Resolve(d.Name)
+	if err != nil {
+		return err
+	}
+
+	d.DSC = resolved.DSC
+	d.Orig = resolved.Orig
+	d.Debian = resolved.Debian
+	d.Checksums = resolved.Checksums
+
+	return nil
+}
+
+// Fetch downloads d's .dsc and tarballs, either from the Sources-resolved
+// URLs or, if d.Snapshot is set, from snapshot.debian.org.
+func (d *Src) Fetch(ctx context.Context) error {
+	if d.Snapshot != "" {
+		return d.fetchFromSnapshot(ctx)
+	}
+
+	links := []string{d.DSC, d.Debian, d.Orig}
+	reqs := make([]*fetch.Request, len(links))
+	dests := make([]string, len(links))
+
+	for i, l := range links {
+		u, err := url.Parse(l)
+		if err != nil {
+			return fmt.Errorf("dpkgsrc: error parsing URL %s: %s", l, err)
+		}
+
+		_, name := filepath.Split(u.Path)
+		dest, dir := buildPath(d.Name, name)
+		if err := ensureDirExists(dir); err != nil {
+			return err
+		}
+
+		dests[i] = dest
+		reqs[i] = &fetch.Request{
+			URL:     u.String(),
+			Mirrors: mirrorURLs(u),
+			Dest:    dest,
+			SHA256:  d.Checksums[name],
+		}
+	}
+
+	f := fetch.New(fetch.DefaultConcurrency)
+	if err := f.FetchAll(ctx, reqs); err != nil {
+		return fmt.Errorf("dpkgsrc: error fetching package files: %s", err)
+	}
+	d.DscPath = dests[0]
+
+	return nil
+}
+
+// fetchFromSnapshot resolves d's .dsc and tarballs against snapshot.debian.org
+// instead of the Sources index, so the same package name and timestamp
+// always yield bit-identical downloads.
+func (d *Src) fetchFromSnapshot(ctx context.Context) error {
+	version, files, err := resolveSnapshot(ctx, d.Name, d.Snapshot)
+	if err != nil {
+		return fmt.Errorf("dpkgsrc: error resolving %s@%s from snapshot.debian.org: %s", d.Name, d.Snapshot, err)
+	}
+	log.Printf("Resolved %s to version %s as of %s", d.Name, version, d.Snapshot)
+
+	for _, f := range files {
+		u, err := url.Parse(snapshotFileURL(f.Hash))
+		if err != nil {
+			return fmt.Errorf("dpkgsrc: error parsing URL for %s: %s", f.Name, err)
+		}
+		path, err := downloadNamed(ctx, u, d.Name, f.Name, f.Hash)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case strings.HasSuffix(f.Name, ".dsc"):
+			d.DscPath = path
+		case strings.HasSuffix(f.Name, ".orig.tar.xz") || strings.HasSuffix(f.Name, ".orig.tar.gz"):
+			d.Orig = f.Name
+		case strings.HasSuffix(f.Name, ".debian.tar.xz"):
+			d.Debian = f.Name
+		}
+	}
+
+	return nil
+}
+
+// Extract unpacks d's .dsc into d.Name's directory via dpkg-source.
+func (d *Src) Extract(ctx context.Context) error {
+	_, dscFile := filepath.Split(d.DscPath)
+	cmd := exec.CommandContext(ctx, "dpkg-source", "-x", "--no-check", dscFile)
+	cmd.Dir = filepath.FromSlash(d.Name)
+
+	cmdReader, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("dpkgsrc: error creating StdoutPipe: %s", err)
+	}
+
+	scanner := bufio.NewScanner(cmdReader)
+	go func() {
+		for scanner.Scan() {
+			log.Println(scanner.Text())
+		}
+	}()
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("dpkgsrc: error starting dpkg-source: %s", err)
+	}
+
+	if err := cmd.Wait(); err != nil {
+		return fmt.Errorf("dpkgsrc: error extracting %s: %s", d.DscPath, err)
+	}
+
+	return nil
+}
+
+// ExtractedDir returns the directory dpkg-source -x unpacked d into, derived
+// from the orig tarball's name (<source>_<upstream-version>.orig.tar.*).
+func (d *Src) ExtractedDir() (string, error) {
+	_, name := filepath.Split(d.Orig)
+
+	var suffix string
+	switch {
+	case strings.HasSuffix(name, ".orig.tar.xz"):
+		suffix = ".orig.tar.xz"
+	case strings.HasSuffix(name, ".orig.tar.gz"):
+		suffix = ".orig.tar.gz"
+	default:
+		return "", fmt.Errorf("dpkgsrc: unrecognized orig tarball name %q", name)
+	}
+
+	source, version, ok := strings.Cut(strings.TrimSuffix(name, suffix), "_")
+	if !ok {
+		return "", fmt.Errorf("dpkgsrc: unrecognized orig tarball name %q", name)
+	}
+
+	return filepath.Join(d.Name, fmt.Sprintf("%s-%s", source, version)), nil
+}
+
+// mirrorURLs returns u rewritten for each of mirrorHosts, for use as fallback
+// download sources.
+func mirrorURLs(u *url.URL) (mirrors []string) {
+	for _, host := range mirrorHosts {
+		if host == u.Host {
+			continue
+		}
+		m := *u
+		m.Host = host
+		if host == "snapshot.debian.org" {
+			m.Path = snapshotArchivePath(u.Path)
+		}
+		mirrors = append(mirrors, m.String())
+	}
+	return
+}
+
+// snapshotArchivePath rewrites a deb.debian.org-style path (e.g.
+// /debian/pool/main/n/nginx/nginx_1.2.3.dsc) into the corresponding
+// snapshot.debian.org archive path. snapshot.debian.org addresses the pool by
+// timestamped directory rather than mirroring the live archive's layout, so
+// there's no single URL that's guaranteed to have today's file; using today's
+// date as the timestamp is a best-effort guess that only helps when the file
+// hasn't moved since it was last snapshotted.
+func snapshotArchivePath(path string) string {
+	rel := strings.TrimPrefix(path, "/debian")
+	today := time.Now().UTC().Format("20060102") + "T000000Z"
+	return "/archive/debian/" + today + rel
+}
+
+func buildPath(pkg, fileName string) (path string, parentDir string) {
+	parentDir = filepath.FromSlash(pkg)
+	path = filepath.Join(parentDir, filepath.FromSlash(fileName))
+	return
+}
+
+func ensureDirExists(dir string) error {
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		if err := os.Mkdir(dir, 0755); err != nil {
+			return fmt.Errorf("dpkgsrc: error creating directory %s: %s", dir, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package dpkgsrc
+
+import "testing"
+
+func TestPickVersionAt(t *testing.T) {
+	candidates := []versionSnapshot{
+		{version: "1.0-1", firstSeen: "20190101T000000Z"},
+		{version: "1.1-1", firstSeen: "20200601T120000Z"},
+		{version: "1.2-1", firstSeen: "20210815T093000Z"},
+	}
+
+	tests := []struct {
+		name      string
+		timestamp string
+		want      string
+		wantOK    bool
+	}{
+		{"exact match", "20200601T120000Z", "1.1-1", true},
+		{"between two versions picks the older", "20201231T000000Z", "1.1-1", true},
+		{"after every version picks the newest", "20300101T000000Z", "1.2-1", true},
+		{"before every version", "20180101T000000Z", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			version, _, ok := pickVersionAt(candidates, tt.timestamp)
+			if ok != tt.wantOK || version != tt.want {
+				t.Errorf("pickVersionAt(..., %q) = (%q, %v), want (%q, %v)", tt.timestamp, version, ok, tt.want, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestPickVersionAtSkipsMissingFirstSeen(t *testing.T) {
+	candidates := []versionSnapshot{
+		{version: "1.0-1", firstSeen: ""},
+		{version: "1.1-1", firstSeen: "20200601T120000Z"},
+	}
+
+	version, _, ok := pickVersionAt(candidates, "20300101T000000Z")
+	if !ok || version != "1.1-1" {
+		t.Errorf("pickVersionAt = (%q, %v), want (1.1-1, true)", version, ok)
+	}
+}
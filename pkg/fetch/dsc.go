@@ -0,0 +1,54 @@
+package fetch
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ParseDSCSha256 reads a .dsc file and returns the SHA-256 digest for each
+// file listed in its "Checksums-Sha256:" block, keyed by filename. That
+// block looks like:
+//
+//	Checksums-Sha256:
+//	 <hex digest> <size> <filename>
+//	 <hex digest> <size> <filename>
+func ParseDSCSha256(path string) (map[string]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("fetch: error opening %s: %s", path, err)
+	}
+	defer file.Close()
+
+	sums := make(map[string]string)
+	inBlock := false
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if !inBlock {
+			if strings.HasPrefix(line, "Checksums-Sha256:") {
+				inBlock = true
+			}
+			continue
+		}
+
+		if !strings.HasPrefix(line, " ") {
+			break
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			continue
+		}
+		sums[fields[2]] = fields[0]
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("fetch: error reading %s: %s", path, err)
+	}
+
+	return sums, nil
+}
@@ -0,0 +1,59 @@
+package fetch
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseDSCSha256(t *testing.T) {
+	const dsc = `Format: 3.0 (quilt)
+Source: nginx
+Version: 1.18.0-6.1
+Checksums-Sha256:
+ e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 1234 nginx_1.18.0-6.1.dsc
+ 9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08 5678 nginx_1.18.0.orig.tar.gz
+Checksums-Sha1:
+ unrelated block that should be ignored
+`
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "nginx_1.18.0-6.1.dsc")
+	if err := os.WriteFile(path, []byte(dsc), 0644); err != nil {
+		t.Fatalf("writing fixture: %s", err)
+	}
+
+	sums, err := ParseDSCSha256(path)
+	if err != nil {
+		t.Fatalf("ParseDSCSha256: %s", err)
+	}
+
+	want := map[string]string{
+		"nginx_1.18.0-6.1.dsc":     "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+		"nginx_1.18.0.orig.tar.gz": "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08",
+	}
+	if len(sums) != len(want) {
+		t.Fatalf("got %d sums, want %d: %v", len(sums), len(want), sums)
+	}
+	for name, hash := range want {
+		if sums[name] != hash {
+			t.Errorf("sums[%q] = %q, want %q", name, sums[name], hash)
+		}
+	}
+}
+
+func TestParseDSCSha256NoChecksumsBlock(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "empty.dsc")
+	if err := os.WriteFile(path, []byte("Format: 3.0 (quilt)\nSource: empty\n"), 0644); err != nil {
+		t.Fatalf("writing fixture: %s", err)
+	}
+
+	sums, err := ParseDSCSha256(path)
+	if err != nil {
+		t.Fatalf("ParseDSCSha256: %s", err)
+	}
+	if len(sums) != 0 {
+		t.Errorf("got %d sums, want 0", len(sums))
+	}
+}
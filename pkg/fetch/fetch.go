@@ -0,0 +1,175 @@
+// Package fetch downloads a batch of files concurrently, resuming partial
+// downloads, verifying checksums, and falling back through mirrors. It's
+// modeled on cavaliergopher/grab, trimmed to the handful of behaviors
+// dpkg-builder needs.
+package fetch
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/remeh/sizedwaitgroup"
+)
+
+// DefaultConcurrency bounds how many files are downloaded at once when a
+// Fetcher is constructed with concurrency <= 0.
+const DefaultConcurrency = 4
+
+// Request describes a single file to fetch: the primary URL, any mirrors to
+// try in order if it 404s or 5xxs, the destination path, and (optionally)
+// the expected SHA-256 digest to verify once the download completes.
+type Request struct {
+	URL     string
+	Mirrors []string
+	Dest    string
+	SHA256  string
+}
+
+// Fetcher downloads a batch of Requests in parallel, bounded by Concurrency.
+type Fetcher struct {
+	Concurrency int
+	Client      *http.Client
+}
+
+// New returns a Fetcher with the given worker pool size. A concurrency of 0
+// or less uses DefaultConcurrency.
+func New(concurrency int) *Fetcher {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &Fetcher{Concurrency: concurrency, Client: http.DefaultClient}
+}
+
+// FetchAll downloads every request, returning the first error encountered
+// (if any) once all downloads have finished. Canceling ctx aborts any
+// in-flight downloads.
+func (f *Fetcher) FetchAll(ctx context.Context, reqs []*Request) error {
+	swg := sizedwaitgroup.New(f.Concurrency)
+	errs := make([]error, len(reqs))
+
+	for i, r := range reqs {
+		swg.Add()
+		go func(i int, r *Request) {
+			defer swg.Done()
+			errs[i] = f.fetchOne(ctx, r)
+		}(i, r)
+	}
+	swg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fetchOne downloads r.URL, falling back to r.Mirrors in order on failure,
+// and verifies r.SHA256 if set.
+func (f *Fetcher) fetchOne(ctx context.Context, r *Request) error {
+	urls := append([]string{r.URL}, r.Mirrors...)
+
+	var lastErr error
+	for _, u := range urls {
+		if err := f.download(ctx, u, r.Dest); err != nil {
+			lastErr = err
+			continue
+		}
+
+		if r.SHA256 != "" {
+			if err := verifySHA256(r.Dest, r.SHA256); err != nil {
+				lastErr = err
+				os.Remove(r.Dest)
+				continue
+			}
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("fetch: all sources failed for %s: %s", r.Dest, lastErr)
+}
+
+// download fetches u into dest, resuming via an HTTP Range request if dest
+// already holds a partial download.
+func (f *Fetcher) download(ctx context.Context, u, dest string) error {
+	var offset int64
+	if fi, err := os.Stat(dest); err == nil {
+		offset = fi.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return fmt.Errorf("fetch: error building request for %s: %s", u, err)
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	resp, err := f.Client.Do(req)
+	if err != nil {
+		return fmt.Errorf("fetch: error downloading %s: %s", u, err)
+	}
+	defer resp.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch resp.StatusCode {
+	case http.StatusOK:
+		flags |= os.O_TRUNC
+	case http.StatusPartialContent:
+		flags |= os.O_APPEND
+	case http.StatusRequestedRangeNotSatisfiable:
+		// Most likely dest is already complete; leave it as-is and let
+		// checksum verification catch anything that isn't.
+		return nil
+	case http.StatusNotFound, http.StatusInternalServerError, http.StatusBadGateway,
+		http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return fmt.Errorf("fetch: %s returned %s", u, resp.Status)
+	default:
+		return fmt.Errorf("fetch: %s returned unexpected status %s", u, resp.Status)
+	}
+
+	file, err := os.OpenFile(dest, flags, 0644)
+	if err != nil {
+		return fmt.Errorf("fetch: error opening %s: %s", dest, err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(file, resp.Body); err != nil {
+		return fmt.Errorf("fetch: error writing %s: %s", dest, err)
+	}
+
+	return nil
+}
+
+// VerifyFile checks that the file at path hashes to expected (a hex-encoded
+// SHA-256 digest). It's exported so callers that fetch a file before they
+// know its expected checksum (e.g. because the checksum lives inside the
+// file itself, as with a .dsc) can verify it after the fact.
+func VerifyFile(path, expected string) error {
+	return verifySHA256(path, expected)
+}
+
+func verifySHA256(path, expected string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("fetch: error opening %s for checksum: %s", path, err)
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return fmt.Errorf("fetch: error hashing %s: %s", path, err)
+	}
+
+	if got := hex.EncodeToString(h.Sum(nil)); got != expected {
+		return fmt.Errorf("fetch: checksum mismatch for %s: got %s, want %s", path, got, expected)
+	}
+
+	return nil
+}
@@ -0,0 +1,241 @@
+// Package sources resolves a Debian source package to its exact .dsc and
+// tarball URLs by parsing a suite's Sources index, rather than scraping
+// packages.debian.org's HTML.
+package sources
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/ulikunitz/xz"
+)
+
+// File is one file belonging to a source package, as listed in its Files:
+// and Checksums-Sha256: fields.
+type File struct {
+	Name   string
+	Size   int64
+	MD5    string
+	SHA256 string
+}
+
+// Package is one paragraph of a Sources index.
+type Package struct {
+	Name      string
+	Version   string
+	Directory string
+	Files     map[string]*File // keyed by filename
+}
+
+// Resolved is the result of looking a package name up in a SourcesIndex: the
+// absolute URLs for its .dsc and tarballs, plus their expected SHA-256 sums.
+type Resolved struct {
+	Version   string
+	DSC       string
+	Orig      string
+	Debian    string
+	Checksums map[string]string // filename -> sha256
+}
+
+// SourcesIndex is a suite/component's Sources file (e.g.
+// dists/bookworm/main/source/Sources.xz), downloaded and parsed once, then
+// queried by package name.
+type SourcesIndex struct {
+	BaseURL   string // e.g. https://deb.debian.org/debian
+	Suite     string // e.g. bookworm, bullseye, sid
+	Component string // e.g. main
+
+	packages map[string]*Package
+}
+
+// New returns a SourcesIndex for the given mirror, suite and component. Call
+// Load before Resolve.
+func New(baseURL, suite, component string) *SourcesIndex {
+	return &SourcesIndex{BaseURL: baseURL, Suite: suite, Component: component}
+}
+
+// Load downloads and parses the index, preferring Sources.xz and falling
+// back to Sources.gz if the mirror doesn't have one.
+func (s *SourcesIndex) Load(ctx context.Context) error {
+	for _, name := range []string{"Sources.xz", "Sources.gz"} {
+		u := fmt.Sprintf("%s/dists/%s/%s/source/%s", s.BaseURL, s.Suite, s.Component, name)
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return fmt.Errorf("sources: error building request for %s: %s", u, err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("sources: error getting %s: %s", u, err)
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			continue
+		}
+
+		r, err := decompress(name, resp.Body)
+		if err != nil {
+			resp.Body.Close()
+			return err
+		}
+
+		packages, err := parse(r)
+		resp.Body.Close()
+		if err != nil {
+			return fmt.Errorf("sources: error parsing %s: %s", u, err)
+		}
+
+		s.packages = packages
+		return nil
+	}
+
+	return fmt.Errorf("sources: no Sources index found for %s/%s/%s", s.BaseURL, s.Suite, s.Component)
+}
+
+func decompress(name string, r io.Reader) (io.Reader, error) {
+	switch {
+	case strings.HasSuffix(name, ".xz"):
+		xr, err := xz.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("sources: error opening xz stream: %s", err)
+		}
+		return xr, nil
+	case strings.HasSuffix(name, ".gz"):
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, fmt.Errorf("sources: error opening gzip stream: %s", err)
+		}
+		return gr, nil
+	default:
+		return r, nil
+	}
+}
+
+// Resolve looks name up in the index and returns its .dsc, .orig.tar.* and
+// .debian.tar.* URLs along with their SHA-256 checksums.
+func (s *SourcesIndex) Resolve(name string) (*Resolved, error) {
+	pkg, ok := s.packages[name]
+	if !ok {
+		return nil, fmt.Errorf("sources: package %q not found in %s/%s", name, s.Suite, s.Component)
+	}
+
+	r := &Resolved{Version: pkg.Version, Checksums: make(map[string]string)}
+	for fname, f := range pkg.Files {
+		u := fmt.Sprintf("%s/%s/%s", s.BaseURL, pkg.Directory, fname)
+		r.Checksums[fname] = f.SHA256
+
+		switch {
+		case strings.HasSuffix(fname, ".dsc"):
+			r.DSC = u
+		case strings.HasSuffix(fname, ".orig.tar.xz") || strings.HasSuffix(fname, ".orig.tar.gz"):
+			r.Orig = u
+		case strings.HasSuffix(fname, ".debian.tar.xz"):
+			r.Debian = u
+		}
+	}
+
+	return r, nil
+}
+
+// parse decodes the RFC822-style paragraphs of a Sources file into Packages
+// keyed by name.
+func parse(r io.Reader) (map[string]*Package, error) {
+	packages := make(map[string]*Package)
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+
+	var cur *Package
+	var field string
+
+	flush := func() {
+		if cur != nil && cur.Name != "" {
+			packages[cur.Name] = cur
+		}
+		cur = nil
+		field = ""
+	}
+
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		if line == "" {
+			flush()
+			continue
+		}
+
+		if strings.HasPrefix(line, " ") {
+			if cur != nil {
+				parseFileLine(cur, field, line)
+			}
+			continue
+		}
+
+		if cur == nil {
+			cur = &Package{Files: make(map[string]*File)}
+		}
+
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "Package":
+			cur.Name = val
+			field = ""
+		case "Version":
+			cur.Version = val
+			field = ""
+		case "Directory":
+			cur.Directory = val
+			field = ""
+		case "Files", "Checksums-Sha256":
+			field = key
+		default:
+			field = ""
+		}
+	}
+	flush()
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return packages, nil
+}
+
+func parseFileLine(pkg *Package, field, line string) {
+	fields := strings.Fields(line)
+	if len(fields) != 3 {
+		return
+	}
+	hash, sizeField, name := fields[0], fields[1], fields[2]
+
+	size, err := strconv.ParseInt(sizeField, 10, 64)
+	if err != nil {
+		return
+	}
+
+	f, ok := pkg.Files[name]
+	if !ok {
+		f = &File{Name: name, Size: size}
+		pkg.Files[name] = f
+	}
+
+	switch field {
+	case "Files":
+		f.MD5 = hash
+	case "Checksums-Sha256":
+		f.SHA256 = hash
+	}
+}
@@ -0,0 +1,96 @@
+package sources
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	const index = `Package: nginx
+Version: 1.18.0-6.1
+Directory: pool/main/n/nginx
+Files:
+ d41d8cd98f00b204e9800998ecf8427e 1234 nginx_1.18.0-6.1.dsc
+ d41d8cd98f00b204e9800998ecf8427e 5678 nginx_1.18.0.orig.tar.gz
+Checksums-Sha256:
+ e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 1234 nginx_1.18.0-6.1.dsc
+ e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 5678 nginx_1.18.0.orig.tar.gz
+
+Package: curl
+Version: 7.88.1-10
+Directory: pool/main/c/curl
+Files:
+ 098f6bcd4621d373cade4e832627b4f6 999 curl_7.88.1-10.dsc
+`
+
+	packages, err := parse(strings.NewReader(index))
+	if err != nil {
+		t.Fatalf("parse: %s", err)
+	}
+
+	if len(packages) != 2 {
+		t.Fatalf("got %d packages, want 2", len(packages))
+	}
+
+	nginx, ok := packages["nginx"]
+	if !ok {
+		t.Fatal("missing nginx package")
+	}
+	if nginx.Version != "1.18.0-6.1" || nginx.Directory != "pool/main/n/nginx" {
+		t.Errorf("nginx = %+v, unexpected version/directory", nginx)
+	}
+
+	dsc, ok := nginx.Files["nginx_1.18.0-6.1.dsc"]
+	if !ok {
+		t.Fatal("missing nginx .dsc file entry")
+	}
+	if dsc.MD5 != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("dsc.MD5 = %q, want the Files: hash", dsc.MD5)
+	}
+	if dsc.SHA256 != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("dsc.SHA256 = %q, want the Checksums-Sha256: hash", dsc.SHA256)
+	}
+	if dsc.Size != 1234 {
+		t.Errorf("dsc.Size = %d, want 1234", dsc.Size)
+	}
+
+	curl, ok := packages["curl"]
+	if !ok {
+		t.Fatal("missing curl package")
+	}
+	if len(curl.Files) != 1 {
+		t.Errorf("curl has %d files, want 1", len(curl.Files))
+	}
+}
+
+func TestParseFileLine(t *testing.T) {
+	pkg := &Package{Files: make(map[string]*File)}
+
+	parseFileLine(pkg, "Files", " d41d8cd98f00b204e9800998ecf8427e 1234 example.dsc")
+	parseFileLine(pkg, "Checksums-Sha256", " e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855 1234 example.dsc")
+
+	f, ok := pkg.Files["example.dsc"]
+	if !ok {
+		t.Fatal("missing example.dsc")
+	}
+	if f.MD5 != "d41d8cd98f00b204e9800998ecf8427e" {
+		t.Errorf("MD5 = %q", f.MD5)
+	}
+	if f.SHA256 != "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855" {
+		t.Errorf("SHA256 = %q", f.SHA256)
+	}
+	if f.Size != 1234 {
+		t.Errorf("Size = %d, want 1234", f.Size)
+	}
+}
+
+func TestParseFileLineMalformed(t *testing.T) {
+	pkg := &Package{Files: make(map[string]*File)}
+
+	parseFileLine(pkg, "Files", " not enough fields")
+	parseFileLine(pkg, "Files", " hash notanumber name")
+
+	if len(pkg.Files) != 0 {
+		t.Errorf("got %d files from malformed lines, want 0", len(pkg.Files))
+	}
+}